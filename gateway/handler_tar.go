@@ -1,9 +1,13 @@
 package gateway
 
 import (
+	"archive/tar"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	ipath "github.com/ipfs/boxo/coreiface/path"
@@ -15,6 +19,11 @@ import (
 
 var unixEpochTime = time.Unix(0, 0)
 
+// tarIndexEntryName is written as the last entry of every TAR response, right
+// before the end-of-archive marker, holding the JSON-encoded tarIndex for the
+// archive that precedes it. See handler_tar_index.go.
+const tarIndexEntryName = ".ipfs-tar-index.json"
+
 func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.Request, imPath ImmutablePath, contentPath ipath.Path, begin time.Time, logger *zap.SugaredLogger) bool {
 	ctx, span := spanTrace(ctx, "Handler.ServeTAR", trace.WithAttributes(attribute.String("path", imPath.String())))
 	defer span.End()
@@ -31,6 +40,26 @@ func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.R
 
 	setIpfsRootsHeader(w, pathMetadata)
 	rootCid := pathMetadata.LastSegment.Cid()
+	rootName := rootCid.String()
+
+	// Walk the DAG once, in the same canonical (lexicographic) order we're
+	// about to serve it in, so the byte layout below is a pure function of
+	// the CID: the same directory always produces the same TAR, entry for
+	// entry, offset for offset.
+	entries, err := walkTAREntries(rootName, file)
+	if err != nil {
+		i.webError(w, r, fmt.Errorf("could not walk directory: %w", err), http.StatusInternalServerError)
+		return false
+	}
+	layout, err := buildTARLayout(entries)
+	if err != nil {
+		i.webError(w, r, fmt.Errorf("could not build tar index: %w", err), http.StatusInternalServerError)
+		return false
+	}
+
+	if r.URL.Query().Get("format") == "tar-index" {
+		return i.serveTARIndex(w, r, layout, begin, contentPath)
+	}
 
 	// Set Cache-Control and read optional Last-Modified time
 	modtime := addCacheControlHeaders(w, r, contentPath, rootCid, tarResponseFormat)
@@ -40,18 +69,10 @@ func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.R
 	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
 		name = urlFilename
 	} else {
-		name = rootCid.String() + ".tar"
+		name = rootName + ".tar"
 	}
 	setContentDispositionHeader(w, name, "attachment")
 
-	// Construct the TAR writer
-	tarw, err := files.NewTarWriter(w)
-	if err != nil {
-		i.webError(w, r, fmt.Errorf("could not build tar writer: %w", err), http.StatusInternalServerError)
-		return false
-	}
-	defer tarw.Close()
-
 	// Sets correct Last-Modified header. This code is borrowed from the standard
 	// library (net/http/server.go) as we cannot use serveFile without throwing the entire
 	// TAR into the memory first.
@@ -61,9 +82,20 @@ func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.R
 
 	w.Header().Set("Content-Type", tarResponseFormat)
 	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+	w.Header().Set("Accept-Ranges", "bytes")
 
-	// The TAR has a top-level directory (or file) named by the CID.
-	if err := tarw.WriteFile(file, rootCid.String()); err != nil {
+	start, end, status, ok := resolveTARRange(r.Header.Get("Range"), layout.totalSize)
+	if !ok {
+		http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+		return false
+	}
+	if status == http.StatusPartialContent {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, layout.totalSize))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start, 10))
+	w.WriteHeader(status)
+
+	if err := writeTARRange(w, layout, start, end); err != nil {
 		// Update fail metric
 		i.tarStreamFailMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
 
@@ -82,3 +114,181 @@ func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.R
 	i.tarStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
 	return true
 }
+
+// resolveTARRange parses a single "bytes=start-end" Range header against a
+// resource of the given total size. With no Range header it returns the
+// whole resource as a 200. Multi-range headers, headers without the
+// "bytes=" unit and specs without a '-' are treated as "no range" (200,
+// whole body) rather than rejected, matching how most of the rest of the
+// gateway prefers to degrade over erroring on odd but harmless client
+// behavior; a single range whose bounds are not valid integers or are out of
+// bounds is rejected with ok=false.
+func resolveTARRange(header string, totalSize int64) (start, end int64, status int, ok bool) {
+	if header == "" || strings.Contains(header, ",") {
+		return 0, totalSize, http.StatusOK, true
+	}
+
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header {
+		return 0, totalSize, http.StatusOK, true
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, totalSize, http.StatusOK, true
+	}
+
+	switch {
+	case parts[0] == "": // suffix range: bytes=-N
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, 0, false
+		}
+		if n > totalSize {
+			n = totalSize
+		}
+		return totalSize - n, totalSize, http.StatusPartialContent, true
+	case parts[1] == "": // open-ended range: bytes=N-
+		start, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || start < 0 || start >= totalSize {
+			return 0, 0, 0, false
+		}
+		return start, totalSize, http.StatusPartialContent, true
+	default:
+		start, err1 := strconv.ParseInt(parts[0], 10, 64)
+		end, err2 := strconv.ParseInt(parts[1], 10, 64)
+		if err1 != nil || err2 != nil || start < 0 || end < start || start >= totalSize {
+			return 0, 0, 0, false
+		}
+		if end >= totalSize {
+			end = totalSize - 1
+		}
+		return start, end + 1, http.StatusPartialContent, true
+	}
+}
+
+// writeTARRange emits exactly the [start, end) byte window of the canonical
+// TAR layout to w. Entries that fall entirely outside the window are never
+// opened or read from the DAG: we know their size from layout alone, so we
+// just advance past them. Entries that overlap the window are written in
+// full through archive/tar (which owns framing/padding); the rangeWriter
+// wrapping w then drops whatever part of that entry falls outside [start, end).
+func writeTARRange(w http.ResponseWriter, layout *tarLayout, start, end int64) error {
+	rw := &rangeWriter{w: w, start: start, end: end}
+	tw := tar.NewWriter(rw)
+
+	for idx, e := range layout.entries {
+		headerOffset := layout.headerOffsets[idx]
+		entryEnd := layout.entryEnds[idx]
+		if entryEnd <= start || headerOffset >= end {
+			rw.skip(entryEnd - headerOffset)
+			continue
+		}
+		if err := writeTAREntry(tw, e); err != nil {
+			return err
+		}
+		// archive/tar defers a file's trailing zero-padding until the next
+		// WriteHeader/Close call; if the following entry is skipped via
+		// rw.skip() instead of going through tw, that padding would only
+		// get flushed once rw.pos has already been advanced past it,
+		// landing it at the wrong offset (and outside [start, end) even
+		// when it should be included). Flush it immediately instead.
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	indexEnd := layout.indexDataOffset + paddedSize(int64(len(layout.indexJSON)))
+	if indexEnd > start && layout.indexHeaderOffset < end {
+		hdr := &tar.Header{
+			Name:     tarIndexEntryName,
+			Mode:     0o644,
+			Size:     int64(len(layout.indexJSON)),
+			ModTime:  unixEpochTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(layout.indexJSON); err != nil {
+			return err
+		}
+	} else {
+		rw.skip(indexEnd - layout.indexHeaderOffset)
+	}
+
+	return tw.Close()
+}
+
+func writeTAREntry(tw *tar.Writer, e tarEntry) error {
+	switch e.kind {
+	case tarEntryDir:
+		hdr := &tar.Header{
+			Name:     e.path + "/",
+			Mode:     0o755,
+			ModTime:  unixEpochTime,
+			Typeflag: tar.TypeDir,
+		}
+		return tw.WriteHeader(hdr)
+	case tarEntrySymlink:
+		hdr := &tar.Header{
+			Name:     e.path,
+			Mode:     0o777,
+			Linkname: e.target,
+			ModTime:  unixEpochTime,
+			Typeflag: tar.TypeSymlink,
+		}
+		return tw.WriteHeader(hdr)
+	}
+
+	hdr := &tar.Header{
+		Name:     e.path,
+		Mode:     0o644,
+		Size:     e.size,
+		ModTime:  unixEpochTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	f, ok := e.node.(files.File)
+	if !ok {
+		return fmt.Errorf("tar: %q is no longer a file", e.path)
+	}
+	_, err := io.Copy(tw, f)
+	return err
+}
+
+// rangeWriter forwards to w only the bytes of a conceptually much larger
+// stream that fall within [start, end); everything else is accounted for
+// (pos still advances) but dropped before it reaches w.
+type rangeWriter struct {
+	w          http.ResponseWriter
+	pos        int64
+	start, end int64
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	lo, hi := rw.pos, rw.pos+int64(len(p))
+	rw.pos = hi
+
+	a, b := lo, hi
+	if rw.start > a {
+		a = rw.start
+	}
+	if rw.end < b {
+		b = rw.end
+	}
+	if a < b {
+		if _, err := rw.w.Write(p[a-lo : b-lo]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// skip advances past n bytes that are never materialized because the
+// caller has already determined they fall outside [start, end).
+func (rw *rangeWriter) skip(n int64) {
+	rw.pos += n
+}
@@ -0,0 +1,187 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	ipath "github.com/ipfs/boxo/coreiface/path"
+	"github.com/ipfs/boxo/files"
+)
+
+const tarHeaderBlockSize = 512
+
+// tarEntryKind distinguishes the three UnixFS node shapes the canonical walk
+// can produce; only tarEntryFile has a data section.
+type tarEntryKind int
+
+const (
+	tarEntryFile tarEntryKind = iota
+	tarEntryDir
+	tarEntrySymlink
+)
+
+// tarEntry is one node of the canonical, lexicographically-ordered walk of a
+// UnixFS directory that backs both the TAR response and its sidecar index.
+type tarEntry struct {
+	path   string
+	node   files.Node
+	kind   tarEntryKind
+	size   int64  // 0 unless kind == tarEntryFile
+	target string // only set when kind == tarEntrySymlink
+}
+
+// walkTAREntries performs a pre-order DAG walk, visiting the children of
+// every directory in sorted-by-name order, and returns the flat sequence in
+// which entries will be written to the TAR. Because the ordering depends
+// only on names already present in the DAG, it is identical on every call
+// for a given CID.
+func walkTAREntries(rootName string, root files.Node) ([]tarEntry, error) {
+	var out []tarEntry
+
+	var walk func(name string, n files.Node) error
+	walk = func(name string, n files.Node) error {
+		switch v := n.(type) {
+		case files.Directory:
+			out = append(out, tarEntry{path: name, node: n, kind: tarEntryDir})
+
+			it := v.Entries()
+			type kid struct {
+				name string
+				node files.Node
+			}
+			var kids []kid
+			for it.Next() {
+				kids = append(kids, kid{it.Name(), it.Node()})
+			}
+			if it.Err() != nil {
+				return it.Err()
+			}
+			sort.Slice(kids, func(a, b int) bool { return kids[a].name < kids[b].name })
+
+			for _, k := range kids {
+				if err := walk(name+"/"+k.name, k.node); err != nil {
+					return err
+				}
+			}
+			return nil
+		case *files.Symlink:
+			out = append(out, tarEntry{path: name, node: n, kind: tarEntrySymlink, target: v.Target})
+			return nil
+		case files.File:
+			size, err := v.Size()
+			if err != nil {
+				return err
+			}
+			out = append(out, tarEntry{path: name, node: n, kind: tarEntryFile, size: size})
+			return nil
+		default:
+			return fmt.Errorf("tar: unsupported node type for %q", name)
+		}
+	}
+
+	if err := walk(rootName, root); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TARIndexEntry locates one file's data within a TAR response produced for
+// the same CID, so a client that already downloaded the index can fetch
+// just that file with a single HTTP Range request.
+type TARIndexEntry struct {
+	Path         string `json:"path"`
+	HeaderOffset int64  `json:"header_offset"`
+	DataOffset   int64  `json:"data_offset"`
+	Size         int64  `json:"size"`
+}
+
+// tarLayout is the byte-accurate accounting of where every entry (including
+// directories, which have no data section) lands in the TAR stream, plus the
+// JSON-encoded index of the file entries alone.
+type tarLayout struct {
+	entries       []tarEntry
+	headerOffsets []int64 // parallel to entries
+	entryEnds     []int64 // parallel to entries; end of this entry's data section (or header, for dirs)
+
+	index     []TARIndexEntry
+	indexJSON []byte
+
+	indexHeaderOffset int64
+	indexDataOffset   int64
+	totalSize         int64
+}
+
+// paddedSize rounds n up to the next multiple of the TAR header block size,
+// matching the padding archive/tar adds after every file's data.
+func paddedSize(n int64) int64 {
+	if rem := n % tarHeaderBlockSize; rem != 0 {
+		return n + (tarHeaderBlockSize - rem)
+	}
+	return n
+}
+
+// buildTARLayout computes the exact offsets entries will land at once
+// written, assuming every header fits in a single 512-byte USTAR block
+// (true for the path lengths UnixFS directories produce in practice; very
+// long paths would additionally need a GNU/PAX long-name header, which this
+// layout does not account for).
+func buildTARLayout(entries []tarEntry) (*tarLayout, error) {
+	layout := &tarLayout{entries: entries}
+
+	var offset int64
+	for _, e := range entries {
+		headerOffset := offset
+		dataOffset := headerOffset + tarHeaderBlockSize
+		layout.headerOffsets = append(layout.headerOffsets, headerOffset)
+
+		if e.kind != tarEntryFile {
+			// Directories and symlinks are header-only: their content (a
+			// target path, for symlinks) lives in the header itself, not in
+			// a following data section.
+			layout.entryEnds = append(layout.entryEnds, dataOffset)
+			offset = dataOffset
+			continue
+		}
+
+		layout.index = append(layout.index, TARIndexEntry{
+			Path:         e.path,
+			HeaderOffset: headerOffset,
+			DataOffset:   dataOffset,
+			Size:         e.size,
+		})
+		offset = dataOffset + paddedSize(e.size)
+		layout.entryEnds = append(layout.entryEnds, offset)
+	}
+
+	indexJSON, err := json.Marshal(layout.index)
+	if err != nil {
+		return nil, fmt.Errorf("tar: encoding index: %w", err)
+	}
+	layout.indexJSON = indexJSON
+	layout.indexHeaderOffset = offset
+	layout.indexDataOffset = offset + tarHeaderBlockSize
+
+	// Index entry's own header+data, then the two zero blocks archive/tar
+	// writes on Close to mark the end of the archive.
+	layout.totalSize = layout.indexDataOffset + paddedSize(int64(len(indexJSON))) + 2*tarHeaderBlockSize
+
+	return layout, nil
+}
+
+// serveTARIndex answers `?format=tar-index` with just the JSON index for
+// the TAR response the gateway would otherwise have streamed for the same
+// path, so a client can discover offsets without downloading any file data.
+func (i *handler) serveTARIndex(w http.ResponseWriter, r *http.Request, layout *tarLayout, begin time.Time, contentPath ipath.Path) bool {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err := w.Write(layout.indexJSON)
+	if err != nil {
+		i.tarStreamFailMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+		return false
+	}
+	i.tarStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	return true
+}
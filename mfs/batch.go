@@ -0,0 +1,256 @@
+package mfs
+
+import (
+	"os"
+	"path"
+	"time"
+
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+type batchOpKind int
+
+const (
+	batchAddChild batchOpKind = iota
+	batchMkdir
+	batchUnlink
+	batchSetMode
+	batchSetModTime
+)
+
+type batchOp struct {
+	kind    batchOpKind
+	name    string
+	node    ipld.Node
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// DirBatch accumulates mutations against a Directory and applies them as a
+// single Commit, instead of each one independently walking up to the root
+// and rewriting every intermediate node the way AddChild/Mkdir/Unlink do
+// when called directly. Bulk importers that add thousands of files pay for
+// one DAG write per directory per Commit instead of one per call. Each
+// recorded op still calls straight into the underlying unixfsDir
+// individually, so any HAMT rebalancing that op triggers happens at that
+// point, not deferred to Commit; what Commit coalesces is everything above
+// unixfsDir, namely the directory's own GetNode/DAGService.Add and the
+// upward updateChildEntry propagation to the root. Deferring the
+// rebalancing itself would need a bulk-insert entry point into the
+// underlying uio.Directory that this package doesn't have access to today;
+// callers inserting enough entries to trigger repeated HAMT reshuffling
+// should expect Commit's savings to come entirely from the coalesced
+// upward write, not from avoiding that per-op rebalancing cost.
+//
+// A DirBatch is not safe for concurrent use, and its operations are only
+// visible to the rest of MFS once Commit succeeds.
+type DirBatch struct {
+	dir *Directory
+	ops []batchOp
+}
+
+// Batch returns a DirBatch that records mutations against d until Commit is
+// called.
+func (d *Directory) Batch() *DirBatch {
+	return &DirBatch{dir: d}
+}
+
+// AddChild records adding nd under name, as Directory.AddChild would.
+func (b *DirBatch) AddChild(name string, nd ipld.Node) {
+	b.ops = append(b.ops, batchOp{kind: batchAddChild, name: name, node: nd})
+}
+
+// Mkdir records creating an empty subdirectory named name, as Directory.Mkdir
+// would.
+func (b *DirBatch) Mkdir(name string) {
+	b.ops = append(b.ops, batchOp{kind: batchMkdir, name: name})
+}
+
+// Unlink records removing the child named name, as Directory.Unlink would.
+func (b *DirBatch) Unlink(name string) {
+	b.ops = append(b.ops, batchOp{kind: batchUnlink, name: name})
+}
+
+// SetMode records setting this directory's own mode, as Directory.SetMode
+// would.
+func (b *DirBatch) SetMode(mode os.FileMode) {
+	b.ops = append(b.ops, batchOp{kind: batchSetMode, mode: mode})
+}
+
+// SetModTime records setting this directory's own modification time, as
+// Directory.SetModTime would.
+func (b *DirBatch) SetModTime(ts time.Time) {
+	b.ops = append(b.ops, batchOp{kind: batchSetModTime, modTime: ts})
+}
+
+// Commit applies every recorded operation to the underlying UnixFS
+// directory, then performs one GetNode of the result (plus one up front to
+// snapshot the directory for rollback), one DAGService.Add of the directory
+// node, and one upward updateChildEntry propagation to the root. If any
+// operation fails,
+// Commit restores the pre-batch entriesCache and unixfsDir before returning
+// the error, leaving the directory exactly as it was before Commit was
+// called.
+func (b *DirBatch) Commit() error {
+	d := b.dir
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	origNode, err := d.unixfsDir.GetNode()
+	if err != nil {
+		return err
+	}
+	origEntriesCache := make(map[string]FSNode, len(d.entriesCache))
+	for k, v := range d.entriesCache {
+		origEntriesCache[k] = v
+	}
+
+	rollback := func() {
+		d.entriesCache = origEntriesCache
+		db, err := uio.NewDirectoryFromNode(d.dagService, origNode)
+		if err != nil {
+			// The node came from GetNode moments ago; this would mean the
+			// DAGService itself is broken, which Commit can't recover from.
+			return
+		}
+		db.SetMaxLinks(d.unixfsDir.GetMaxLinks())
+		db.SetMaxHAMTFanout(d.unixfsDir.GetMaxHAMTFanout())
+		d.unixfsDir = db
+	}
+
+	var modeSet, modTimeSet bool
+	var mode os.FileMode
+	var modTime time.Time
+	var pending []Event
+
+	for _, op := range b.ops {
+		switch op.kind {
+		case batchAddChild:
+			if _, err := d.childUnsync(op.name); err == nil {
+				rollback()
+				return ErrDirExists
+			}
+			if err := d.dagService.Add(d.ctx, op.node); err != nil {
+				rollback()
+				return err
+			}
+			if err := d.unixfsDir.AddChild(d.ctx, op.name, op.node); err != nil {
+				rollback()
+				return err
+			}
+			delete(d.entriesCache, op.name)
+			pending = append(pending, Event{Type: EventCreated, Path: path.Join(d.Path(), op.name), Cid: op.node.Cid()})
+
+		case batchMkdir:
+			if _, err := d.childUnsync(op.name); err == nil {
+				rollback()
+				return os.ErrExist
+			}
+			opts := MkdirOpts{
+				MaxLinks:      d.unixfsDir.GetMaxLinks(),
+				MaxHAMTFanout: d.unixfsDir.GetMaxHAMTFanout(),
+				CidBuilder:    d.GetCidBuilder(),
+			}
+			dirobj, err := NewEmptyDirectory(d.ctx, op.name, d, d.dagService, opts)
+			if err != nil {
+				rollback()
+				return err
+			}
+			ndir, err := dirobj.GetNode()
+			if err != nil {
+				rollback()
+				return err
+			}
+			if err := d.unixfsDir.AddChild(d.ctx, op.name, ndir); err != nil {
+				rollback()
+				return err
+			}
+			d.entriesCache[op.name] = dirobj
+			pending = append(pending, Event{Type: EventCreated, Path: path.Join(d.Path(), op.name), Cid: ndir.Cid()})
+
+		case batchUnlink:
+			delete(d.entriesCache, op.name)
+			if err := d.unixfsDir.RemoveChild(d.ctx, op.name); err != nil {
+				rollback()
+				return err
+			}
+			pending = append(pending, Event{Type: EventRemoved, Path: path.Join(d.Path(), op.name)})
+
+		case batchSetMode:
+			modeSet, mode = true, op.mode
+
+		case batchSetModTime:
+			modTimeSet, modTime = true, op.modTime
+		}
+	}
+
+	if err := d.cacheSync(false); err != nil {
+		rollback()
+		return err
+	}
+
+	nd, err := d.unixfsDir.GetNode()
+	if err != nil {
+		rollback()
+		return err
+	}
+
+	if modeSet || modTimeSet {
+		fsn, err := ft.ExtractFSNode(nd)
+		if err != nil {
+			rollback()
+			return err
+		}
+		if modeSet {
+			fsn.SetMode(mode)
+		}
+		if modTimeSet {
+			fsn.SetModTime(modTime)
+		}
+		data, err := fsn.GetBytes()
+		if err != nil {
+			rollback()
+			return err
+		}
+
+		patched := dag.NodeWithData(data)
+		patched.SetLinks(nd.Links())
+		nd = patched
+
+		if modeSet {
+			d.unixfsDir.SetStat(mode, time.Time{})
+		}
+		if modTimeSet {
+			d.unixfsDir.SetStat(0, modTime)
+		}
+	}
+
+	pbnd, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		rollback()
+		return dag.ErrNotProtobuf
+	}
+
+	if err := d.dagService.Add(d.ctx, pbnd); err != nil {
+		rollback()
+		return err
+	}
+
+	if err := d.parent.updateChildEntry(child{d.name, pbnd}); err != nil {
+		rollback()
+		return err
+	}
+
+	if modeSet || modTimeSet {
+		pending = append(pending, Event{Type: EventModified, Path: d.Path(), Cid: pbnd.Cid()})
+	}
+	for _, ev := range pending {
+		d.publish(ev)
+	}
+
+	b.ops = nil
+	return nil
+}
@@ -0,0 +1,55 @@
+package mfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+)
+
+// DirBatch.Commit needs a fully-wired Directory — dagService, a parent Root,
+// entriesCache — to exercise end to end, and Root/parent/FSNode/inode/child
+// all live outside this trimmed snapshot (see dir.go's own references to
+// them), so there's no way to construct a real Directory here without
+// fabricating core MFS plumbing this change didn't touch. What is testable
+// in isolation is the op-recording half of DirBatch, which never touches the
+// Directory until Commit is called.
+func TestDirBatchRecordsOpsWithoutTouchingDirectory(t *testing.T) {
+	b := &DirBatch{}
+
+	nd := dag.NodeWithData([]byte("a"))
+	b.AddChild("a", nd)
+	b.Mkdir("sub")
+	b.Unlink("old")
+	b.SetMode(0o644)
+	modTime := time.Unix(1700000000, 0)
+	b.SetModTime(modTime)
+
+	if len(b.ops) != 5 {
+		t.Fatalf("got %d recorded ops, want 5", len(b.ops))
+	}
+
+	wantKinds := []batchOpKind{batchAddChild, batchMkdir, batchUnlink, batchSetMode, batchSetModTime}
+	for i, k := range wantKinds {
+		if b.ops[i].kind != k {
+			t.Fatalf("op %d kind = %v, want %v", i, b.ops[i].kind, k)
+		}
+	}
+
+	if b.ops[0].name != "a" || !b.ops[0].node.Cid().Equals(nd.Cid()) {
+		t.Fatalf("AddChild op recorded wrong name/node: %+v", b.ops[0])
+	}
+	if b.ops[1].name != "sub" {
+		t.Fatalf("Mkdir op recorded wrong name: %+v", b.ops[1])
+	}
+	if b.ops[2].name != "old" {
+		t.Fatalf("Unlink op recorded wrong name: %+v", b.ops[2])
+	}
+	if b.ops[3].mode != os.FileMode(0o644) {
+		t.Fatalf("SetMode op recorded wrong mode: %+v", b.ops[3])
+	}
+	if !b.ops[4].modTime.Equal(modTime) {
+		t.Fatalf("SetModTime op recorded wrong time: %+v", b.ops[4])
+	}
+}
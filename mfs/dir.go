@@ -157,7 +157,9 @@ func (d *Directory) localUpdate(c child) (*dag.ProtoNode, error) {
 		return nil, err
 	}
 
-	return pbnd.Copy().(*dag.ProtoNode), nil
+	out := pbnd.Copy().(*dag.ProtoNode)
+	d.publish(Event{Type: EventModified, Path: d.Path(), Cid: out.Cid()})
+	return out, nil
 	// TODO: Why do we need a copy?
 }
 
@@ -354,6 +356,7 @@ func (d *Directory) MkdirWithOpts(name string, opts MkdirOpts) (*Directory, erro
 	}
 
 	d.entriesCache[name] = dirobj
+	d.publish(Event{Type: EventCreated, Path: path.Join(d.Path(), name), Cid: ndir.Cid()})
 	return dirobj, nil
 }
 
@@ -363,7 +366,59 @@ func (d *Directory) Unlink(name string) error {
 
 	delete(d.entriesCache, name)
 
-	return d.unixfsDir.RemoveChild(d.ctx, name)
+	if err := d.unixfsDir.RemoveChild(d.ctx, name); err != nil {
+		return err
+	}
+	d.publish(Event{Type: EventRemoved, Path: path.Join(d.Path(), name)})
+	return nil
+}
+
+// Rename changes the name a child is stored under from oldName to newName,
+// without otherwise touching its content, and publishes an EventRenamed
+// rather than the EventRemoved+EventCreated pair a Unlink+AddChild sequence
+// would produce. Moving a child to a different Directory has no dedicated
+// operation; do that as Unlink from the source followed by AddChild/Mkdir
+// on the destination.
+func (d *Directory) Rename(oldName, newName string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, err := d.childUnsync(newName); err == nil {
+		return ErrDirExists
+	}
+
+	nd, err := d.childFromDag(oldName)
+	if err != nil {
+		return err
+	}
+
+	// Add the new name before removing the old one, so that if AddChild
+	// fails the directory still has the entry under its old name rather
+	// than having silently lost it: the alternative order (remove then
+	// add) leaves no way back if the add half fails.
+	if err := d.unixfsDir.AddChild(d.ctx, newName, nd); err != nil {
+		return err
+	}
+	if err := d.unixfsDir.RemoveChild(d.ctx, oldName); err != nil {
+		if rmErr := d.unixfsDir.RemoveChild(d.ctx, newName); rmErr != nil {
+			// Leaves newName as a duplicate alias of oldName rather than
+			// losing the entry; the original RemoveChild error above is
+			// still what's reported.
+			_ = rmErr
+		}
+		return err
+	}
+
+	delete(d.entriesCache, oldName)
+	delete(d.entriesCache, newName)
+
+	d.publish(Event{
+		Type: EventRenamed,
+		Path: path.Join(d.Path(), newName),
+		Cid:  nd.Cid(),
+		From: path.Join(d.Path(), oldName),
+	})
+	return nil
 }
 
 func (d *Directory) Flush() error {
@@ -390,7 +445,11 @@ func (d *Directory) AddChild(name string, nd ipld.Node) error {
 		return err
 	}
 
-	return d.unixfsDir.AddChild(d.ctx, name, nd)
+	if err := d.unixfsDir.AddChild(d.ctx, name, nd); err != nil {
+		return err
+	}
+	d.publish(Event{Type: EventCreated, Path: path.Join(d.Path(), name), Cid: nd.Cid()})
+	return nil
 }
 
 func (d *Directory) cacheSync(clean bool) error {
@@ -518,6 +577,7 @@ func (d *Directory) setNodeData(data []byte, links []*ipld.Link) error {
 	if err != nil {
 		return err
 	}
+	d.publish(Event{Type: EventModified, Path: d.Path(), Cid: nd.Cid()})
 
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -0,0 +1,482 @@
+package mfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	chunker "github.com/ipfs/boxo/chunker"
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/balanced"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/rfjakob/eme"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// cryptHeaderName is the hidden entry each EncryptedDirectory keeps
+	// alongside its (encrypted) children, holding the parameters needed to
+	// re-derive the directory's key from a passphrase.
+	cryptHeaderName = ".mfscrypt"
+	cryptMagic      = "MFSC"
+	cryptVersion    = 1
+
+	cryptSaltSize  = 16
+	cryptKeySize   = 32 // one key, used for both name and content encryption
+	cryptChunkSize = 64 * 1024
+)
+
+var ErrWrongPassphrase = errors.New("mfs: passphrase does not match directory's crypt header")
+
+// EncryptedDirectory wraps a regular *Directory and transparently encrypts
+// both entry names and file contents at rest, modeled on rclone's crypt
+// backend: filenames go through EME (a wide-block AES mode) and are
+// base32-encoded so the result is still a valid, deterministic path
+// component; file contents are split into ~64 KiB chunks and sealed with
+// an AEAD, each under its own per-file nonce prefix and per-chunk counter.
+type EncryptedDirectory struct {
+	*Directory
+
+	nameBlock eme.BlockMode
+	aead      cipher.AEAD
+}
+
+// cryptCheckPlaintext is EME-encrypted under the directory's derived key and
+// stored alongside the salt, purely so a wrong passphrase can be detected
+// up front instead of surfacing as garbled names and failed AEAD opens
+// later on.
+const cryptCheckPlaintext = cryptMagic
+
+type cryptHeader struct {
+	salt  [cryptSaltSize]byte
+	check [16]byte // emePad(cryptCheckPlaintext) encrypted under nameBlock; zero until known
+}
+
+// NewEncryptedDirectory wraps dir so all further Mkdir/AddChild/Unlink/
+// ForEachEntry calls operate on encrypted names and, for files, encrypted
+// contents. If dir already has a crypt header (from a previous session),
+// passphrase must reproduce the same key or ErrWrongPassphrase is returned.
+// Otherwise a new header, keyed by a freshly generated salt, is written.
+func NewEncryptedDirectory(ctx context.Context, dir *Directory, passphrase []byte) (*EncryptedDirectory, error) {
+	hdr, isNew, err := loadOrCreateCryptHeader(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(passphrase, hdr.salt[:], 1<<15, 8, 1, cryptKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("mfs: deriving key: %w", err)
+	}
+
+	nameBlock, aead, err := newCryptPrimitives(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := &EncryptedDirectory{
+		Directory: dir,
+		nameBlock: nameBlock,
+		aead:      aead,
+	}
+
+	if isNew {
+		copy(hdr.check[:], nameBlock.Encrypt(emePad([]byte(cryptCheckPlaintext))))
+		if err := ed.writeCryptHeader(ctx, hdr); err != nil {
+			return nil, err
+		}
+		return ed, nil
+	}
+
+	// Confirm the derived key is actually correct by decrypting the
+	// header's check value; scrypt alone can't tell us that, since any
+	// passphrase produces *a* key.
+	plain, err := emeUnpad(nameBlock.Decrypt(hdr.check[:]))
+	if err != nil || plain != cryptCheckPlaintext {
+		return nil, ErrWrongPassphrase
+	}
+
+	return ed, nil
+}
+
+// newCryptPrimitives derives the two primitives every EncryptedDirectory
+// needs from a single key: an EME block cipher over AES for names (EME is a
+// wide-block mode, so it can scramble a whole short filename deterministically
+// without an IV), and an XChaCha20-Poly1305 AEAD for chunked file contents.
+func newCryptPrimitives(key []byte) (eme.BlockMode, cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mfs: building name cipher: %w", err)
+	}
+	nameBlock := eme.New(block)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mfs: building content cipher: %w", err)
+	}
+
+	return nameBlock, aead, nil
+}
+
+// loadOrCreateCryptHeader is split out from NewEncryptedDirectory only to
+// keep the "is this directory already encrypted" branch readable.
+func loadOrCreateCryptHeader(ctx context.Context, dir *Directory) (cryptHeader, bool, error) {
+	fsn, err := dir.Child(cryptHeaderName)
+	if err == nil {
+		hdr, err := readCryptHeader(fsn)
+		return hdr, false, err
+	}
+
+	var hdr cryptHeader
+	if _, err := io.ReadFull(rand.Reader, hdr.salt[:]); err != nil {
+		return hdr, false, fmt.Errorf("mfs: generating salt: %w", err)
+	}
+	return hdr, true, nil
+}
+
+// readCryptHeader decodes an existing .mfscrypt entry's raw bytes back into
+// a cryptHeader, checking only the fixed-size framing (magic, version,
+// length); whether the passphrase that produced it is the right one is left
+// for the caller to decide by decrypting hdr.check.
+func readCryptHeader(fsn FSNode) (cryptHeader, error) {
+	var hdr cryptHeader
+
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return hdr, fmt.Errorf("mfs: reading crypt header: %w", err)
+	}
+	data := nd.RawData()
+
+	wantLen := len(cryptMagic) + 1 + cryptSaltSize + len(hdr.check)
+	if len(data) != wantLen {
+		return hdr, fmt.Errorf("mfs: crypt header has wrong size %d, want %d", len(data), wantLen)
+	}
+	if string(data[:len(cryptMagic)]) != cryptMagic {
+		return hdr, errors.New("mfs: crypt header has bad magic")
+	}
+	data = data[len(cryptMagic):]
+	if data[0] != cryptVersion {
+		return hdr, fmt.Errorf("mfs: crypt header has unsupported version %d", data[0])
+	}
+	data = data[1:]
+
+	copy(hdr.salt[:], data[:cryptSaltSize])
+	data = data[cryptSaltSize:]
+	copy(hdr.check[:], data)
+
+	return hdr, nil
+}
+
+func (ed *EncryptedDirectory) writeCryptHeader(ctx context.Context, hdr cryptHeader) error {
+	buf := make([]byte, 0, len(cryptMagic)+1+cryptSaltSize+len(hdr.check))
+	buf = append(buf, cryptMagic...)
+	buf = append(buf, byte(cryptVersion))
+	buf = append(buf, hdr.salt[:]...)
+	buf = append(buf, hdr.check[:]...)
+
+	nd, err := nodeFromBytes(ctx, ed.dagService, buf)
+	if err != nil {
+		return err
+	}
+	return ed.Directory.AddChild(cryptHeaderName, nd)
+}
+
+// encryptName deterministically maps a plaintext child name to the name
+// under which it is actually stored, so that Child(name) can recompute the
+// ciphertext name and look it up directly instead of scanning every entry.
+func (ed *EncryptedDirectory) encryptName(name string) string {
+	padded := emePad([]byte(name))
+	ct := ed.nameBlock.Encrypt(padded)
+	return base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(ct)
+}
+
+func (ed *EncryptedDirectory) decryptName(stored string) (string, error) {
+	if stored == cryptHeaderName {
+		return "", errors.New("mfs: crypt header is not a visible entry")
+	}
+	ct, err := base32.HexEncoding.WithPadding(base32.NoPadding).DecodeString(stored)
+	if err != nil {
+		return "", fmt.Errorf("mfs: invalid encrypted name %q: %w", stored, err)
+	}
+	padded := ed.nameBlock.Decrypt(ct)
+	return emeUnpad(padded)
+}
+
+// Mkdir creates a child directory whose on-disk name is the encryption of
+// name, and returns an EncryptedDirectory wrapping it so the whole subtree
+// stays transparently encrypted.
+func (ed *EncryptedDirectory) Mkdir(name string) (*EncryptedDirectory, error) {
+	sub, err := ed.Directory.Mkdir(ed.encryptName(name))
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedDirectory{Directory: sub, nameBlock: ed.nameBlock, aead: ed.aead}, nil
+}
+
+// AddChild encrypts name and streams an encrypted copy of the file named by
+// plaintext through r into the underlying UnixFS layer, preserving sharding
+// and the directory's CID builder (both are properties of the *Directory*
+// node, which AddChild delegates to unmodified).
+func (ed *EncryptedDirectory) AddChild(ctx context.Context, name string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(ed.encryptStream(pw, r))
+	}()
+
+	nd, err := chunkToNode(ctx, ed.dagService, pr)
+	if err != nil {
+		return err
+	}
+	return ed.Directory.AddChild(ed.encryptName(name), nd)
+}
+
+// Unlink removes the child stored under the encryption of name.
+func (ed *EncryptedDirectory) Unlink(name string) error {
+	return ed.Directory.Unlink(ed.encryptName(name))
+}
+
+// Child looks up the child stored under the encryption of name. A
+// subdirectory comes back wrapped as an *EncryptedDirectory, sharing this
+// directory's key, so the caller can keep recursing into the encrypted
+// subtree; a file comes back as-is (still encrypted on disk) since reading
+// its plaintext goes through Open, not through the raw FSNode.
+func (ed *EncryptedDirectory) Child(name string) (FSNode, error) {
+	fsn, err := ed.Directory.Child(ed.encryptName(name))
+	if err != nil {
+		return nil, err
+	}
+	if sub, ok := fsn.(*Directory); ok {
+		return &EncryptedDirectory{Directory: sub, nameBlock: ed.nameBlock, aead: ed.aead}, nil
+	}
+	return fsn, nil
+}
+
+// Open returns a reader over the plaintext content of the file named by
+// (plaintext) name — the inverse of AddChild. It looks up the (still
+// encrypted) child, opens a DAG reader over its raw bytes, and decrypts
+// that ciphertext chunk by chunk, checking each chunk's finality tag along
+// the way, so a stream truncated at a chunk boundary surfaces as an error
+// from Read rather than silently handing back a short file.
+func (ed *EncryptedDirectory) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	fsn, err := ed.Directory.Child(ed.encryptName(name))
+	if err != nil {
+		return nil, err
+	}
+	f, ok := fsn.(*File)
+	if !ok {
+		return nil, fmt.Errorf("mfs: %q is not a file", name)
+	}
+	nd, err := f.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	ctReader, err := uio.NewDagReader(ctx, nd, ed.dagService)
+	if err != nil {
+		return nil, fmt.Errorf("mfs: opening %q: %w", name, err)
+	}
+	return ed.decryptStream(ctReader), nil
+}
+
+// ForEachEntry calls f once per visible (i.e. not the crypt header) child,
+// with Name already decrypted back to plaintext.
+func (ed *EncryptedDirectory) ForEachEntry(ctx context.Context, f func(NodeListing) error) error {
+	return ed.Directory.ForEachEntry(ctx, func(nl NodeListing) error {
+		if nl.Name == cryptHeaderName {
+			return nil
+		}
+		name, err := ed.decryptName(nl.Name)
+		if err != nil {
+			return err
+		}
+		nl.Name = name
+		return f(nl)
+	})
+}
+
+// chunkAD is the AEAD associated data sealed alongside every chunk
+// encryptStream writes, committing each chunk to whether it is the last one
+// in the file. Without this, a reader has no cryptographic way to tell
+// "the stream ended because the file is over" from "the stream ended
+// because something truncated it" — both look like a clean EOF after the
+// last chunk that happened to be delivered.
+func chunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptStream writes unframed ciphertext chunks to w (every chunk but the
+// last is exactly cryptChunkSize+Overhead bytes, which is how decryptStream
+// finds the boundaries): a random
+// per-file nonce prefix, then one sealed chunk per cryptChunkSize bytes of
+// plaintext (the last chunk may be shorter), each keyed by prefix||counter
+// and authenticated as final or not via chunkAD.
+func (ed *EncryptedDirectory) encryptStream(w io.Writer, r io.Reader) error {
+	prefixLen := ed.aead.NonceSize() - 8 // last 8 bytes of the nonce are the chunk counter
+	prefix := make([]byte, prefixLen)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, ed.aead.NonceSize())
+	copy(nonce, prefix)
+
+	cur := make([]byte, cryptChunkSize)
+	n, err := io.ReadFull(r, cur)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	var counter uint64
+	for {
+		next := make([]byte, cryptChunkSize)
+		nn, nerr := io.ReadFull(r, next)
+		if nerr != nil && nerr != io.ErrUnexpectedEOF && nerr != io.EOF {
+			return nerr
+		}
+		// Only a zero-byte read with no more data coming tells us cur was
+		// the last chunk; a short (but non-empty) read just means cur's
+		// successor happens to be the final, partial one.
+		final := nn == 0 && nerr == io.EOF
+
+		binary.BigEndian.PutUint64(nonce[prefixLen:], counter)
+		ct := ed.aead.Seal(nil, nonce, cur[:n], chunkAD(final))
+		if _, werr := w.Write(ct); werr != nil {
+			return werr
+		}
+		if final {
+			return nil
+		}
+
+		counter++
+		cur, n = next, nn
+	}
+}
+
+// decryptStream is the inverse of encryptStream: it reads the nonce prefix,
+// then one ciphertext chunk at a time, opening each against nonce
+// prefix||counter and the chunkAD finality tag the matching encryptStream
+// call sealed it with. ctReader is closed once the plaintext side has been
+// fully delivered (or decryption fails); the returned ReadCloser streams
+// decrypted plaintext and must itself be closed by the caller if abandoned
+// before EOF.
+func (ed *EncryptedDirectory) decryptStream(ctReader io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer ctReader.Close()
+
+		prefixLen := ed.aead.NonceSize() - 8
+		prefix := make([]byte, prefixLen)
+		if _, err := io.ReadFull(ctReader, prefix); err != nil {
+			pw.CloseWithError(fmt.Errorf("mfs: reading nonce prefix: %w", err))
+			return
+		}
+		nonce := make([]byte, ed.aead.NonceSize())
+		copy(nonce, prefix)
+
+		br := bufio.NewReader(ctReader)
+		cipherChunkSize := cryptChunkSize + ed.aead.Overhead()
+		buf := make([]byte, cipherChunkSize)
+
+		var counter uint64
+		for {
+			n, err := io.ReadFull(br, buf)
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				pw.CloseWithError(fmt.Errorf("mfs: reading chunk %d: %w", counter, err))
+				return
+			}
+			if n == 0 {
+				pw.CloseWithError(fmt.Errorf("mfs: truncated encrypted stream: no chunks read"))
+				return
+			}
+
+			// Peek rather than read the next chunk so that a final,
+			// exactly-cipherChunkSize-long chunk isn't mistaken for a
+			// non-final one: the only reliable signal that no more
+			// ciphertext follows is the underlying reader itself reporting
+			// EOF.
+			_, peekErr := br.Peek(1)
+			final := peekErr != nil
+
+			binary.BigEndian.PutUint64(nonce[prefixLen:], counter)
+			plain, err := ed.aead.Open(nil, nonce, buf[:n], chunkAD(final))
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("mfs: decrypting chunk %d: %w", counter, err))
+				return
+			}
+			if _, err := pw.Write(plain); err != nil {
+				return
+			}
+			if final {
+				pw.Close()
+				return
+			}
+			counter++
+		}
+	}()
+
+	return pr
+}
+
+func emePad(b []byte) []byte {
+	const block = 16
+	pad := block - (len(b) % block)
+	out := make([]byte, len(b)+pad)
+	copy(out, b)
+	for i := len(b); i < len(out); i++ {
+		out[i] = byte(pad)
+	}
+	return out
+}
+
+func emeUnpad(b []byte) (string, error) {
+	if len(b) == 0 || len(b)%16 != 0 {
+		return "", errors.New("mfs: corrupt padded name")
+	}
+	pad := int(b[len(b)-1])
+	if pad <= 0 || pad > 16 || pad > len(b) {
+		return "", errors.New("mfs: corrupt padding")
+	}
+	return string(b[:len(b)-pad]), nil
+}
+
+// nodeFromBytes wraps a small blob (e.g. the crypt header) as a single raw
+// UnixFS node, the same representation used for small files added through
+// the importer below.
+func nodeFromBytes(ctx context.Context, dserv ipld.DAGService, data []byte) (ipld.Node, error) {
+	nd := dag.NewRawNode(data)
+	if err := dserv.Add(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// chunkToNode runs the ciphertext stream through the same chunker+balanced
+// layout the importer uses for regular UnixFS files, so encrypted files get
+// ordinary UnixFS file nodes (and thus ordinary sharding behavior) rather
+// than a special-cased representation.
+func chunkToNode(ctx context.Context, dserv ipld.DAGService, r io.Reader) (ipld.Node, error) {
+	dbp := &uio.DagBuilderParams{
+		Dagserv:    dserv,
+		RawLeaves:  true,
+		CidBuilder: nil,
+	}
+	db, err := dbp.New(chunker.DefaultSplitter(r))
+	if err != nil {
+		return nil, err
+	}
+	return balanced.Layout(db)
+}
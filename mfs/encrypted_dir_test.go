@@ -0,0 +1,63 @@
+package mfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestEncryptDecryptStreamRoundTrip(t *testing.T) {
+	key := make([]byte, cryptKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	_, aead, err := newCryptPrimitives(key)
+	if err != nil {
+		t.Fatalf("newCryptPrimitives: %v", err)
+	}
+	ed := &EncryptedDirectory{aead: aead}
+
+	plain := bytes.Repeat([]byte("hello world, round tripping the chunked AEAD stream. "), 2000)
+
+	var ciphertext bytes.Buffer
+	if err := ed.encryptStream(&ciphertext, bytes.NewReader(plain)); err != nil {
+		t.Fatalf("encryptStream: %v", err)
+	}
+
+	dr := ed.decryptStream(io.NopCloser(bytes.NewReader(ciphertext.Bytes())))
+	defer dr.Close()
+
+	got, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatalf("decryptStream: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d bytes", len(got), len(plain))
+	}
+}
+
+func TestDecryptStreamRejectsTruncation(t *testing.T) {
+	key := make([]byte, cryptKeySize)
+	_, aead, err := newCryptPrimitives(key)
+	if err != nil {
+		t.Fatalf("newCryptPrimitives: %v", err)
+	}
+	ed := &EncryptedDirectory{aead: aead}
+
+	plain := bytes.Repeat([]byte("x"), cryptChunkSize*2+10)
+
+	var ciphertext bytes.Buffer
+	if err := ed.encryptStream(&ciphertext, bytes.NewReader(plain)); err != nil {
+		t.Fatalf("encryptStream: %v", err)
+	}
+
+	// Drop the final chunk so the stream ends mid-file: the reader must
+	// surface an error rather than silently handing back a short file.
+	truncated := ciphertext.Bytes()[:ciphertext.Len()-(10+aead.Overhead())]
+	dr := ed.decryptStream(io.NopCloser(bytes.NewReader(truncated)))
+	defer dr.Close()
+
+	if _, err := io.ReadAll(dr); err == nil {
+		t.Fatal("expected an error reading a truncated encrypted stream, got nil")
+	}
+}
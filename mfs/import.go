@@ -0,0 +1,169 @@
+package mfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	car "github.com/ipld/go-car/v2"
+)
+
+// ErrCARRootNotFound is returned by ImportCAR and ImportHTTP when the CAR
+// stream never yields a block matching the caller-supplied root CID.
+var ErrCARRootNotFound = fmt.Errorf("mfs: root CID not found in CAR stream")
+
+// ImportCAR reads a CARv1 or CARv2 stream from r, verifying as it goes that
+// every block hashes to the CID it is keyed under, then grafts rootCid under
+// this directory as name. Blocks are verified and persisted to the
+// DAGService one at a time as they arrive, so a large import never holds
+// more than one block in memory; what's deferred until the whole stream has
+// been consumed is only the directory mutation itself. Before that mutation
+// happens, ImportCAR walks rootCid's links against the set of CIDs actually
+// seen in *this* stream (not just whatever the DAGService happens to already
+// have) to confirm every block the root transitively references was
+// present: on any hash mismatch, a root that never shows up, or an interior
+// link missing from the stream, no entry is added to the directory.
+func (d *Directory) ImportCAR(ctx context.Context, name string, r io.Reader, rootCid cid.Cid) error {
+	cr, err := car.NewBlockReader(r)
+	if err != nil {
+		return fmt.Errorf("mfs: opening CAR: %w", err)
+	}
+
+	seen := make(map[cid.Cid]struct{})
+	found := false
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("mfs: reading CAR: %w", err)
+		}
+
+		// Recompute the hash ourselves; never trust the CID the stream
+		// claims a block is keyed under.
+		ok, err := blk.Cid().Prefix().Sum(blk.RawData())
+		if err != nil {
+			return fmt.Errorf("mfs: hashing block %s: %w", blk.Cid(), err)
+		}
+		if !ok.Equals(blk.Cid()) {
+			return fmt.Errorf("mfs: block %s does not hash to its claimed CID", blk.Cid())
+		}
+
+		nd, err := ipld.Decode(blk)
+		if err != nil {
+			return fmt.Errorf("mfs: decoding block %s: %w", blk.Cid(), err)
+		}
+		if err := d.dagService.Add(ctx, nd); err != nil {
+			return fmt.Errorf("mfs: persisting block %s: %w", blk.Cid(), err)
+		}
+
+		seen[blk.Cid()] = struct{}{}
+		if blk.Cid().Equals(rootCid) {
+			found = true
+		}
+	}
+	if !found {
+		return ErrCARRootNotFound
+	}
+
+	if err := verifyCARReachable(ctx, d.dagService, rootCid, seen); err != nil {
+		return err
+	}
+
+	rootNode, err := d.dagService.Get(ctx, rootCid)
+	if err != nil {
+		return fmt.Errorf("mfs: root %s not reachable after import: %w", rootCid, err)
+	}
+
+	return d.AddChild(name, rootNode)
+}
+
+// verifyCARReachable walks every link reachable from root and fails unless
+// each one is in seen, i.e. was actually read (and hash-verified) from the
+// CAR stream currently being imported. Checking against seen rather than
+// just asking the DAGService for each link matters: the DAGService may
+// already hold an unrelated block under the same CID from a prior import,
+// which would otherwise let a CAR that's missing an interior block pass
+// silently.
+func verifyCARReachable(ctx context.Context, dserv ipld.DAGService, root cid.Cid, seen map[cid.Cid]struct{}) error {
+	visited := make(map[cid.Cid]struct{})
+
+	var walk func(c cid.Cid) error
+	walk = func(c cid.Cid) error {
+		if _, ok := visited[c]; ok {
+			return nil
+		}
+		visited[c] = struct{}{}
+
+		if _, ok := seen[c]; !ok {
+			return fmt.Errorf("mfs: CAR is missing block %s referenced from the DAG", c)
+		}
+
+		nd, err := dserv.Get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("mfs: fetching %s: %w", c, err)
+		}
+		for _, l := range nd.Links() {
+			if err := walk(l.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(root)
+}
+
+// ImportHTTP fetches a CAR for rootCid from url and imports it via
+// ImportCAR. It asks for application/vnd.ipld.car and, if the server
+// doesn't honor content negotiation, retries once with a `?format=car`
+// query parameter before giving up.
+func (d *Directory) ImportHTTP(ctx context.Context, name, url string, rootCid cid.Cid) error {
+	body, err := fetchCAR(ctx, url, false)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = d.ImportCAR(ctx, name, body, rootCid)
+	if err == nil {
+		return nil
+	}
+
+	// The server may have ignored our Accept header and returned something
+	// that isn't a CAR at all; fall back to the explicit query parameter
+	// before surfacing the original error.
+	body2, ferr := fetchCAR(ctx, url, true)
+	if ferr != nil {
+		return err
+	}
+	defer body2.Close()
+
+	return d.ImportCAR(ctx, name, body2, rootCid)
+}
+
+func fetchCAR(ctx context.Context, url string, formatFallback bool) (io.ReadCloser, error) {
+	if formatFallback {
+		url = url + "?format=car"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("mfs: building CAR request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mfs: fetching CAR from %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("mfs: fetching CAR from %s: unexpected status %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
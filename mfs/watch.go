@@ -0,0 +1,236 @@
+package mfs
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"weak"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrNotRooted is returned by Directory.Watch when the directory has no
+// Root in its parent chain yet, so there is no per-tree hub to subscribe to.
+var ErrNotRooted = errors.New("mfs: directory has no root to watch")
+
+// EventType identifies what kind of change an Event describes.
+type EventType int
+
+const (
+	// EventCreated is emitted when a new file or directory is added.
+	EventCreated EventType = iota
+	// EventRemoved is emitted when a file or directory is unlinked.
+	EventRemoved
+	// EventModified is emitted when a file or directory's own node changes,
+	// including directories whose CID changed only because a descendant
+	// changed.
+	EventModified
+	// EventRenamed is emitted when an entry's name changes; From is set to
+	// its path prior to the rename.
+	EventRenamed
+	// EventOverflow is emitted in place of whatever events a slow
+	// subscriber couldn't keep up with; it carries no Path or Cid.
+	EventOverflow
+)
+
+// Event describes a single change to an MFS tree, as delivered by
+// Directory.Watch or Root.Watch.
+type Event struct {
+	Type EventType
+	Path string
+	Cid  cid.Cid
+	From string // only set for EventRenamed
+}
+
+// watchSubBuffer is the number of events buffered per subscriber before
+// further events are replaced with a single EventOverflow.
+const watchSubBuffer = 64
+
+type watchHub struct {
+	mu       sync.Mutex
+	nextID   int
+	subs     map[int]chan Event
+	overflow map[int]bool // subscribers owed an EventOverflow
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{subs: make(map[int]chan Event), overflow: make(map[int]bool)}
+}
+
+func (h *watchHub) subscribe(ctx context.Context) <-chan Event {
+	h.mu.Lock()
+	id := h.nextID
+	h.nextID++
+	ch := make(chan Event, watchSubBuffer)
+	h.subs[id] = ch
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subs, id)
+		delete(h.overflow, id)
+		h.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
+
+// publish delivers ev to every current subscriber without blocking. A
+// subscriber whose buffer is full never blocks the mutation that triggered
+// ev; instead, once room frees up, it receives a single EventOverflow
+// standing in for whatever was dropped.
+func (h *watchHub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id, ch := range h.subs {
+		if h.overflow[id] {
+			select {
+			case ch <- Event{Type: EventOverflow}:
+				delete(h.overflow, id)
+			default:
+				// Still no room: ev is dropped too, and the one
+				// pending overflow marker stands in for it as well.
+				continue
+			}
+		}
+		select {
+		case ch <- ev:
+		default:
+			h.overflow[id] = true
+		}
+	}
+}
+
+var (
+	rootHubsLock sync.Mutex
+	rootHubs     = make(map[weak.Pointer[Root]]*watchHub)
+)
+
+// hubFor returns r's watchHub, creating one on first use. Hubs are kept in
+// a package-level registry, keyed by Root identity, rather than as a field
+// on Root itself, so that Watch support doesn't require every other part of
+// MFS that constructs or copies a Root to know about it. The registry is
+// keyed by a weak.Pointer rather than r itself: a plain map key would hold
+// a strong reference to r, which would mean r could never become
+// unreachable in the first place, so a cleanup keyed on "r is unreachable"
+// could never fire. Indexing by weak.Pointer lets r be collected normally;
+// runtime.AddCleanup then drops its hub from the registry once that happens,
+// instead of pinning it forever. Subscriber goroutines are not stopped by
+// that; each still exits only when its own ctx is canceled.
+func hubFor(r *Root) *watchHub {
+	wp := weak.Make(r)
+
+	rootHubsLock.Lock()
+	defer rootHubsLock.Unlock()
+
+	h, ok := rootHubs[wp]
+	if !ok {
+		h = newWatchHub()
+		rootHubs[wp] = h
+		runtime.AddCleanup(r, removeHub, wp)
+	}
+	return h
+}
+
+// removeHub is registered via runtime.AddCleanup by hubFor; it runs once r
+// has become unreachable, so nothing outside this package can still call
+// Watch on it again.
+func removeHub(wp weak.Pointer[Root]) {
+	rootHubsLock.Lock()
+	delete(rootHubs, wp)
+	rootHubsLock.Unlock()
+}
+
+// root walks up this directory's parent chain and returns its Root.
+func (d *Directory) root() *Root {
+	var cur parent = d
+	for {
+		switch p := cur.(type) {
+		case *Directory:
+			cur = p.parent
+		case *Root:
+			return p
+		default:
+			return nil
+		}
+	}
+}
+
+// hub returns the watchHub for this directory's Root, or nil if the
+// directory isn't (yet) rooted.
+func (d *Directory) hub() *watchHub {
+	r := d.root()
+	if r == nil {
+		return nil
+	}
+	return hubFor(r)
+}
+
+// Watch returns a channel of events for every mutation anywhere in root's
+// tree, until ctx is canceled.
+func (r *Root) Watch(ctx context.Context) (<-chan Event, error) {
+	h := hubFor(r)
+	out := make(chan Event, watchSubBuffer)
+	src := h.subscribe(ctx)
+	go func() {
+		defer close(out)
+		for ev := range src {
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// publish notifies this directory's Root's subscribers of ev, doing nothing
+// if the tree has no Root (should not happen outside of tests that build a
+// Directory without one).
+func (d *Directory) publish(ev Event) {
+	if h := d.hub(); h != nil {
+		h.publish(ev)
+	}
+}
+
+// Watch returns a channel of events for every mutation anywhere in this
+// directory's subtree, until ctx is canceled. The channel is closed once
+// ctx is done; callers that stop reading before then will eventually see an
+// EventOverflow rather than unbounded buffering or a blocked writer.
+func (d *Directory) Watch(ctx context.Context) (<-chan Event, error) {
+	h := d.hub()
+	if h == nil {
+		return nil, ErrNotRooted
+	}
+
+	prefix := d.Path()
+	src := h.subscribe(ctx)
+	out := make(chan Event, watchSubBuffer)
+	go func() {
+		defer close(out)
+		for ev := range src {
+			if ev.Type != EventOverflow && !isUnderPath(prefix, ev.Path) {
+				continue
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func isUnderPath(prefix, p string) bool {
+	if prefix == "/" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}